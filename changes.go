@@ -72,7 +72,8 @@ func (g *Commands) resolveChangeListRecv(
 		}
 	}
 
-	// TODO: limit the number of active tasks for children lookups
+	// TODO: limit the number of active tasks for children lookups; only
+	// their in-flight API calls are limited by the remote's traversal limiter
 	dirlist := merge(remoteChildren, localChildren)
 	var wg sync.WaitGroup
 	wg.Add(len(dirlist))
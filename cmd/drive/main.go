@@ -34,6 +34,7 @@ const (
 	descPush    = "push local changes to google drive"
 	descDiff    = "compares a local file with remote"
 	descPublish = "publishes a file and prints its publicly available url"
+	descDoctor  = "diagnoses common problems with the context and the remote"
 )
 
 func main() {
@@ -42,6 +43,7 @@ func main() {
 	command.On("push", descPush, &pushCmd{}, []string{})
 	command.On("diff", descDiff, &diffCmd{}, []string{})
 	command.On("pub", descPublish, &publishCmd{}, []string{})
+	command.On("doctor", descDoctor, &doctorCmd{}, []string{})
 	command.ParseAndRun()
 }
 
@@ -124,6 +126,31 @@ func (cmd *publishCmd) Run(args []string) {
 	}).Publish())
 }
 
+type doctorCmd struct {
+	isRecursive *bool
+}
+
+func (cmd *doctorCmd) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	cmd.isRecursive = fs.Bool("r", false, "checks the subdirectories of path for clashing names")
+	return fs
+}
+
+func (cmd *doctorCmd) Run(args []string) {
+	// problems with the context are reported by the doctor itself
+	context, _ = config.Discover(getContextPath(args))
+	relPath := ""
+	if context != nil && len(args) > 0 {
+		var err error
+		relPath, err = filepath.Rel(context.AbsPath, args[0])
+		exitWithError(err)
+	}
+	exitWithError(drive.New(context, &drive.Options{
+		Path:         relPath,
+		IsRecursive:  *cmd.isRecursive,
+		IsClashCheck: len(args) > 0,
+	}).Doctor())
+}
+
 func initContext(args []string) *config.Context {
 	var err error
 	context, err = config.Initialize(getContextPath(args))
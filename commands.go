@@ -33,6 +33,8 @@ type Options struct {
 	IsForce     bool
 	// Hidden discovers hidden paths if set
 	Hidden bool
+	// IsClashCheck makes doctor look for clashing remote names under Path
+	IsClashCheck bool
 }
 
 type Commands struct {
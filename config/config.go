@@ -17,29 +17,75 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 )
 
+// Wildcard key in Context.Exports, applied to every Google mime type.
+const AnyMimeType = "*"
+
 type Context struct {
 	ClientId     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	RefreshToken string `json:"refresh_token"`
 	AbsPath      string `json:"-"`
+	// Exports overrides the export formats of Google docs, keyed by their
+	// Google mime type or AnyMimeType. Formats are listed in preference
+	// order; later ones are used if the document can't be exported to
+	// the earlier ones.
+	Exports map[string][]ExportFormat `json:"exports,omitempty"`
+}
+
+// ExportFormat is a target mime type to export a Google doc to and the
+// extension appended to the local file name.
+type ExportFormat struct {
+	MimeType string `json:"mime_type"`
+	Ext      string `json:"ext"`
 }
 
 func (c *Context) AbsPathOf(fileOrDirPath string) string {
 	return path.Join(c.AbsPath, fileOrDirPath)
 }
 
+// CredentialsPath returns the path of the file the context is stored in.
+func (c *Context) CredentialsPath() string {
+	return credentialsPath(c.AbsPath)
+}
+
 func (c *Context) Read() (err error) {
 	var data []byte
 	if data, err = ioutil.ReadFile(credentialsPath(c.AbsPath)); err != nil {
 		return
 	}
-	err = json.Unmarshal(data, c)
-	return
+	if err = json.Unmarshal(data, c); err != nil {
+		return
+	}
+	return c.validateExports()
+}
+
+// validateExports makes sure every export format has a mime type and an
+// extension which can't escape the exported file's name.
+func (c *Context) validateExports() error {
+	for mimeType, formats := range c.Exports {
+		if mimeType == "" {
+			return errors.New("exports: empty mime type key")
+		}
+		for _, f := range formats {
+			if f.MimeType == "" {
+				return fmt.Errorf("exports: %s: empty mime_type", mimeType)
+			}
+			if f.Ext == "" {
+				return fmt.Errorf("exports: %s: empty ext for %s", mimeType, f.MimeType)
+			}
+			if strings.ContainsAny(f.Ext, `/\`) || strings.Contains(f.Ext, "..") {
+				return fmt.Errorf("exports: %s: invalid ext %q for %s", mimeType, f.Ext, f.MimeType)
+			}
+		}
+	}
+	return nil
 }
 
 func (c *Context) Write() (err error) {
@@ -82,6 +128,11 @@ func Initialize(absPath string) (c *Context, err error) {
 		return
 	}
 	c = &Context{AbsPath: absPath}
+	// keep the settings of a previous init, only the credentials are
+	// renewed; invalid exports are kept too so they can be fixed by hand
+	prev := &Context{AbsPath: absPath}
+	prev.Read()
+	c.Exports = prev.Exports
 	err = c.Write()
 	return
 }
@@ -0,0 +1,97 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestInitializeKeepsExports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Initialize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports := map[string][]ExportFormat{
+		AnyMimeType: {{MimeType: "application/pdf", Ext: "pdf"}},
+	}
+	c.RefreshToken = "old"
+	c.Exports = exports
+	if err = c.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err = Initialize(dir); err != nil {
+		t.Fatal(err)
+	}
+	if c.RefreshToken != "" {
+		t.Errorf("RefreshToken = %q, want it reset", c.RefreshToken)
+	}
+	if !reflect.DeepEqual(c.Exports, exports) {
+		t.Errorf("Exports = %v, want %v", c.Exports, exports)
+	}
+	read := &Context{AbsPath: dir}
+	if err = read.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Exports, exports) {
+		t.Errorf("stored Exports = %v, want %v", read.Exports, exports)
+	}
+}
+
+func TestReadValidatesExports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(gdPath(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		exports string
+		valid   bool
+	}{
+		{`{}`, true},
+		{`{"*": [{"mime_type": "application/pdf", "ext": "pdf"}]}`, true},
+		{`{"*": [{"mime_type": "application/x-tar", "ext": "tar.gz"}]}`, true},
+		{`{"": [{"mime_type": "application/pdf", "ext": "pdf"}]}`, false},
+		{`{"*": [{"mime_type": "", "ext": "pdf"}]}`, false},
+		{`{"*": [{"mime_type": "application/pdf", "ext": ""}]}`, false},
+		{`{"*": [{"mime_type": "application/pdf", "ext": "../pdf"}]}`, false},
+		{`{"*": [{"mime_type": "application/pdf", "ext": "a/pdf"}]}`, false},
+		{`{"*": [{"mime_type": "application/pdf", "ext": "a\\pdf"}]}`, false},
+		{`{"*": [{"mime_type": "application/pdf", "ext": ".."}]}`, false},
+	}
+	for _, tt := range tests {
+		data := []byte(`{"exports": ` + tt.exports + `}`)
+		if err = ioutil.WriteFile(credentialsPath(dir), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		err = (&Context{AbsPath: dir}).Read()
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("Read with exports %s: err = %v, want valid = %v", tt.exports, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// Clock skew tolerated before modification times become unreliable.
+	maxClockSkew = time.Minute
+
+	// Ratio of used storage over which the quota is reported.
+	quotaWarnRatio = 0.9
+)
+
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(format string, a ...interface{}) {
+	fmt.Println("ok  ", fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) warn(format string, a ...interface{}) {
+	fmt.Println("warn", fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) skip(format string, a ...interface{}) {
+	fmt.Println("skip", fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) fail(format string, a ...interface{}) {
+	d.problems++
+	fmt.Println("fail", fmt.Sprintf(format, a...))
+}
+
+// Doctor checks the context, credentials, connectivity and, if
+// IsClashCheck is set, the remote under the path for the usual causes of
+// failing pulls and pushes, and prints what to do about them.
+func (g *Commands) Doctor() error {
+	d := &doctor{}
+	if g.checkContext(d) && g.checkConnectivity(d) && g.checkCredentials(d) {
+		g.checkQuota(d)
+		g.checkClashes(d)
+	}
+	if d.problems > 0 {
+		return fmt.Errorf("found %d problem(s)", d.problems)
+	}
+	return nil
+}
+
+func (g *Commands) checkContext(d *doctor) bool {
+	if g.context == nil {
+		d.fail("no gd context is found; use drive init")
+		return false
+	}
+	d.ok("context at %s", g.context.AbsPath)
+	credsPath := g.context.CredentialsPath()
+	info, err := os.Stat(credsPath)
+	if err != nil {
+		d.fail("cannot stat %s: %v; use drive init", credsPath, err)
+		return false
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		d.warn("%s is accessible by other users; chmod 600 it", credsPath)
+	}
+	if err = g.context.Read(); err != nil {
+		d.fail("cannot read %s: %v; use drive init", credsPath, err)
+		return false
+	}
+	if g.context.ClientId == "" || g.context.ClientSecret == "" || g.context.RefreshToken == "" {
+		d.fail("credentials are incomplete; use drive init")
+		return false
+	}
+	return true
+}
+
+func (g *Commands) checkConnectivity(d *doctor) bool {
+	serverTime, err := g.rem.ServerTime()
+	if err != nil && err != ErrNoServerTime {
+		d.fail("cannot reach %s: %v; check your network and proxy settings", GoogleAPIsURL, err)
+		return false
+	}
+	d.ok("connected to %s", GoogleAPIsURL)
+	if err == ErrNoServerTime {
+		d.skip("clock skew; %v", err)
+		return true
+	}
+	if skew, ok := clockSkew(time.Now(), serverTime); !ok {
+		d.fail("local clock is off by %v; sync it, modification times are compared with the remote's", skew)
+	} else {
+		d.ok("clock skew is %v", skew)
+	}
+	return true
+}
+
+// clockSkew returns how far apart the local and server times are, and
+// whether that is within maxClockSkew.
+func clockSkew(local, server time.Time) (skew time.Duration, ok bool) {
+	skew = local.Sub(server)
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew, skew <= maxClockSkew
+}
+
+func (g *Commands) checkCredentials(d *doctor) bool {
+	scopes, err := g.rem.TokenScopes()
+	if err != nil {
+		d.fail("credentials are rejected: %v; use drive init to reauthorize", err)
+		return false
+	}
+	for _, s := range scopes {
+		if s == DriveScope {
+			d.ok("credentials are valid")
+			return true
+		}
+	}
+	d.fail("credentials lack the %s scope; use drive init to reauthorize", DriveScope)
+	return false
+}
+
+func (g *Commands) checkQuota(d *doctor) {
+	total, used, trashed, err := g.rem.Quota()
+	if err != nil {
+		d.fail("cannot retrieve the quota: %v", err)
+		return
+	}
+	if isQuotaLow(total, used) {
+		d.warn("%d of %d bytes used, %d by trashed files; pushes may fail", used, total, trashed)
+		return
+	}
+	d.ok("%d of %d bytes used", used, total)
+}
+
+// isQuotaLow reports whether used is over quotaWarnRatio of total. A zero
+// total means unlimited storage.
+func isQuotaLow(total, used int64) bool {
+	return total > 0 && float64(used) >= quotaWarnRatio*float64(total)
+}
+
+func (g *Commands) checkClashes(d *doctor) {
+	if !g.opts.IsClashCheck {
+		d.skip("clashing names; pass a path to check it, -r to include its subdirectories")
+		return
+	}
+	r, err := g.rem.FindByPath(g.opts.Path)
+	if err != nil {
+		d.fail("cannot find %s on the remote: %v", g.opts.Path, err)
+		return
+	}
+	if !r.IsDir {
+		d.skip("%s is a file, no names to check", g.opts.Path)
+		return
+	}
+	fmt.Println("Checking", g.opts.Path, "for clashing names...")
+	var clashes [][]string
+	if clashes, err = findClashesRecv(g.rem.FindByParentId, g.opts.Path, r.Id, g.opts.IsRecursive); err != nil {
+		d.fail("cannot list %s on the remote: %v", g.opts.Path, err)
+		return
+	}
+	for _, c := range clashes {
+		if isSameName(c) {
+			d.fail("%s is the name of %d remote files; rename or remove all but one", c[0], len(c))
+		} else {
+			d.fail("%s clash on case-insensitive file systems; rename all but one", strings.Join(c, ", "))
+		}
+	}
+	if len(clashes) == 0 {
+		d.ok("no clashing names under %s", g.opts.Path)
+	}
+}
+
+// findClashesRecv returns the groups of paths under p whose names are the
+// same, ignoring case. children lists the files of a directory by its id.
+func findClashesRecv(children func(id string) ([]*File, error), p string, parentId string, isRecursive bool) (clashes [][]string, err error) {
+	var files []*File
+	if files, err = children(parentId); err != nil {
+		return
+	}
+	clashes = clashingNames(p, files)
+	if !isRecursive {
+		return
+	}
+	for _, f := range files {
+		if !f.IsDir {
+			continue
+		}
+		var childClashes [][]string
+		if childClashes, err = findClashesRecv(children, path.Join(p, f.Name), f.Id, isRecursive); err != nil {
+			return
+		}
+		clashes = append(clashes, childClashes...)
+	}
+	return
+}
+
+// clashingNames groups the paths of files under p whose names are the
+// same, ignoring case. Groups are ordered by the first file in each.
+func clashingNames(p string, files []*File) (clashes [][]string) {
+	var keys []string
+	groups := make(map[string][]string)
+	for _, f := range files {
+		key := strings.ToLower(f.Name)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], path.Join(p, f.Name))
+	}
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			clashes = append(clashes, groups[key])
+		}
+	}
+	return
+}
+
+func isSameName(paths []string) bool {
+	for _, p := range paths[1:] {
+		if p != paths[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClashingNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  [][]string
+	}{
+		{nil, nil},
+		{[]string{"a", "b", "c"}, nil},
+		{[]string{"a", "a"}, [][]string{{"/d/a", "/d/a"}}},
+		{[]string{"Foo", "foo"}, [][]string{{"/d/Foo", "/d/foo"}}},
+		{[]string{"A", "b", "a", "a"}, [][]string{{"/d/A", "/d/a", "/d/a"}}},
+		{[]string{"b", "a", "B", "A"}, [][]string{{"/d/b", "/d/B"}, {"/d/a", "/d/A"}}},
+	}
+	for _, tt := range tests {
+		var files []*File
+		for _, n := range tt.names {
+			files = append(files, &File{Name: n})
+		}
+		if got := clashingNames("/d", files); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("clashingNames(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestIsSameName(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  bool
+	}{
+		{[]string{"/a", "/a"}, true},
+		{[]string{"/a", "/a", "/a"}, true},
+		{[]string{"/A", "/a"}, false},
+		{[]string{"/A", "/a", "/A"}, false},
+		{[]string{"/a", "/A", "/a"}, false},
+	}
+	for _, tt := range tests {
+		if got := isSameName(tt.paths); got != tt.want {
+			t.Errorf("isSameName(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestFindClashesRecv(t *testing.T) {
+	tree := map[string][]*File{
+		"root": {{Id: "1", Name: "docs", IsDir: true}, {Name: "x"}, {Name: "X"}},
+		"1":    {{Name: "a"}, {Name: "a"}},
+	}
+	children := func(id string) ([]*File, error) {
+		return tree[id], nil
+	}
+
+	got, err := findClashesRecv(children, "/", "root", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"/x", "/X"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("non-recursive clashes = %v, want %v", got, want)
+	}
+
+	if got, err = findClashesRecv(children, "/", "root", true); err != nil {
+		t.Fatal(err)
+	}
+	want = [][]string{{"/x", "/X"}, {"/docs/a", "/docs/a"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recursive clashes = %v, want %v", got, want)
+	}
+
+	boom := errors.New("boom")
+	failing := func(id string) ([]*File, error) {
+		if id == "1" {
+			return nil, boom
+		}
+		return tree[id], nil
+	}
+	if _, err = findClashesRecv(failing, "/", "root", true); err != boom {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		server time.Time
+		skew   time.Duration
+		ok     bool
+	}{
+		{now, 0, true},
+		{now.Add(-30 * time.Second), 30 * time.Second, true},
+		{now.Add(30 * time.Second), 30 * time.Second, true},
+		{now.Add(maxClockSkew), maxClockSkew, true},
+		{now.Add(-2 * time.Minute), 2 * time.Minute, false},
+		{now.Add(2 * time.Minute), 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		skew, ok := clockSkew(now, tt.server)
+		if skew != tt.skew || ok != tt.ok {
+			t.Errorf("clockSkew(now, now%+v) = %v, %v; want %v, %v",
+				tt.server.Sub(now), skew, ok, tt.skew, tt.ok)
+		}
+	}
+}
+
+func TestIsQuotaLow(t *testing.T) {
+	tests := []struct {
+		total, used int64
+		want        bool
+	}{
+		{0, 0, false},
+		{0, 1 << 40, false},
+		{100, 0, false},
+		{100, 89, false},
+		{100, 90, true},
+		{100, 120, true},
+	}
+	for _, tt := range tests {
+		if got := isQuotaLow(tt.total, tt.used); got != tt.want {
+			t.Errorf("isQuotaLow(%d, %d) = %v, want %v", tt.total, tt.used, got, tt.want)
+		}
+	}
+}
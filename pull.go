@@ -18,31 +18,46 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
 	"path/filepath"
+	"strings"
 	"sync"
+
+	"github.com/rakyll/drive/config"
 )
 
 const (
 	maxNumOfConcPullTasks = 4
 )
 
-func docExportsMap() *map[string][]string {
-	return &map[string][]string {
-		"text/plain": []string{"text/plain", "txt",},
-		"application/vnd.google-apps.drawing": []string{"image/svg+xml", "svg+xml",},
-		"application/vnd.google-apps.spreadsheet": []string{
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx",
-		},
-		"application/vnd.google-apps.document": []string{
-			"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "docx",
-		},
-		"application/vnd.google-apps.presentation": []string{
-			"application/vnd.openxmlformats-officedocument.presentationml.presentation", "pptx",
-		},
+func docExportsMap() map[string][]config.ExportFormat {
+	return map[string][]config.ExportFormat{
+		"text/plain":                          {{MimeType: "text/plain", Ext: "txt"}},
+		"application/vnd.google-apps.drawing": {{MimeType: "image/svg+xml", Ext: "svg+xml"}},
+		"application/vnd.google-apps.spreadsheet": {{
+			MimeType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			Ext:      "xlsx",
+		}},
+		"application/vnd.google-apps.document": {{
+			MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			Ext:      "docx",
+		}},
+		"application/vnd.google-apps.presentation": {{
+			MimeType: "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+			Ext:      "pptx",
+		}},
 	}
 }
 
+// exportFormats lists the candidate export formats of mimeType in order of
+// preference: the context's overrides for the type, then for any type,
+// then the built-in defaults and finally plain text.
+func exportFormats(context *config.Context, mimeType string) (formats []config.ExportFormat) {
+	formats = append(formats, context.Exports[mimeType]...)
+	formats = append(formats, context.Exports[config.AnyMimeType]...)
+	formats = append(formats, docExportsMap()[mimeType]...)
+	return append(formats, config.ExportFormat{MimeType: "text/plain", Ext: "txt"})
+}
+
 // Pull from remote if remote path exists and in a god context. If path is a
 // directory, it recursively pulls from the remote if there are remote changes.
 // It doesn't check if there are remote changes if isForce is set.
@@ -71,6 +86,7 @@ func (g *Commands) Pull() (err error) {
 
 func (g *Commands) playPullChangeList(cl []*Change) (err error) {
 	var next []*Change
+	var mu sync.Mutex
 	g.taskStart(len(cl))
 
 	for {
@@ -87,14 +103,23 @@ func (g *Commands) playPullChangeList(cl []*Change) (err error) {
 		// play the changes
 		// TODO: add timeouts
 		for _, c := range next {
-			switch c.Op() {
-			case OpMod:
-				go g.localMod(&wg, c)
-			case OpAdd:
-				go g.localAdd(&wg, c)
-			case OpDelete:
-				go g.localDelete(&wg, c)
-			}
+			go func(c *Change) {
+				defer wg.Done()
+				var e error
+				switch c.Op() {
+				case OpMod:
+					e = g.localMod(c)
+				case OpAdd:
+					e = g.localAdd(c)
+				case OpDelete:
+					e = g.localDelete(c)
+				}
+				if e != nil {
+					mu.Lock()
+					err = e
+					mu.Unlock()
+				}
+			}(c)
 		}
 		wg.Wait()
 	}
@@ -103,9 +128,8 @@ func (g *Commands) playPullChangeList(cl []*Change) (err error) {
 	return err
 }
 
-func (g *Commands) localMod(wg *sync.WaitGroup, change *Change) (err error) {
+func (g *Commands) localMod(change *Change) (err error) {
 	defer g.taskDone()
-	defer wg.Done()
 	destAbsPath := g.context.AbsPathOf(change.Path)
 
 	if change.Src.BlobAt != "" || change.Src.ExportLinks != nil {
@@ -117,9 +141,8 @@ func (g *Commands) localMod(wg *sync.WaitGroup, change *Change) (err error) {
 	return os.Chtimes(destAbsPath, change.Src.ModTime, change.Src.ModTime)
 }
 
-func (g *Commands) localAdd(wg *sync.WaitGroup, change *Change) (err error) {
+func (g *Commands) localAdd(change *Change) (err error) {
 	defer g.taskDone()
-	defer wg.Done()
 	destAbsPath := g.context.AbsPathOf(change.Path)
 	// make parent's dir if not exists
 	os.MkdirAll(filepath.Dir(destAbsPath), os.ModeDir|0755)
@@ -135,9 +158,8 @@ func (g *Commands) localAdd(wg *sync.WaitGroup, change *Change) (err error) {
 	return os.Chtimes(destAbsPath, change.Src.ModTime, change.Src.ModTime)
 }
 
-func (g *Commands) localDelete(wg *sync.WaitGroup, change *Change) (err error) {
+func (g *Commands) localDelete(change *Change) (err error) {
 	defer g.taskDone()
-	defer wg.Done()
 	return os.RemoveAll(change.Dest.BlobAt)
 }
 
@@ -151,18 +173,24 @@ func (g *Commands) download(change *Change) (err error) {
 	// We also need to pay attention and add the exported extension
 	// to avoid overriding the original file on re-syncing.
 	if len(change.Src.BlobAt) < 1 {
-		var ok bool
-		var mimeKeyExtList[]string
-
-		exportsMap := *docExportsMap()
-		mimeKeyExtList, ok = exportsMap[change.Src.MimeType]
-		if !ok {
-			mimeKeyExtList = []string{"text/plain", "txt"}
+		var format config.ExportFormat
+		formats := exportFormats(g.context, change.Src.MimeType)
+		for _, format = range formats {
+			if exportUrl = change.Src.ExportLinks[format.MimeType]; exportUrl != "" {
+				break
+			}
+		}
+		if exportUrl == "" {
+			var tried []string
+			for _, f := range formats {
+				tried = append(tried, f.MimeType)
+			}
+			return fmt.Errorf("%s: cannot export %s to any of %s",
+				change.Path, change.Src.MimeType, strings.Join(tried, ", "))
 		}
 
-		exportUrl = change.Src.ExportLinks[mimeKeyExtList[0]]
 		fmt.Print("Exported ", baseName)
-		baseName = strings.Join([]string{baseName, mimeKeyExtList[1]}, ".")
+		baseName = strings.Join([]string{baseName, format.Ext}, ".")
 		fmt.Println(" to: ", baseName)
 	}
 
@@ -0,0 +1,92 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rakyll/drive/config"
+)
+
+const docMimeType = "application/vnd.google-apps.document"
+
+func TestExportFormatsDefaults(t *testing.T) {
+	got := exportFormats(&config.Context{}, docMimeType)
+	want := []config.ExportFormat{
+		{MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Ext: "docx"},
+		{MimeType: "text/plain", Ext: "txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exportFormats = %v, want %v", got, want)
+	}
+	got = exportFormats(&config.Context{}, "application/vnd.google-apps.unknown")
+	want = []config.ExportFormat{{MimeType: "text/plain", Ext: "txt"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exportFormats of an unknown type = %v, want %v", got, want)
+	}
+}
+
+func TestExportFormatsOverrides(t *testing.T) {
+	odt := config.ExportFormat{MimeType: "application/vnd.oasis.opendocument.text", Ext: "odt"}
+	pdf := config.ExportFormat{MimeType: "application/pdf", Ext: "pdf"}
+	context := &config.Context{Exports: map[string][]config.ExportFormat{
+		docMimeType:        {odt},
+		config.AnyMimeType: {pdf},
+	}}
+	got := exportFormats(context, docMimeType)
+	want := []config.ExportFormat{
+		odt,
+		pdf,
+		{MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Ext: "docx"},
+		{MimeType: "text/plain", Ext: "txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exportFormats = %v, want %v", got, want)
+	}
+	got = exportFormats(context, "application/vnd.google-apps.drawing")
+	if got[0] != pdf {
+		t.Errorf("exportFormats of a drawing starts with %v, want %v", got[0], pdf)
+	}
+}
+
+func TestDownloadWithoutExportLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &Commands{context: &config.Context{AbsPath: dir}}
+	err = g.download(&Change{
+		Path: "/doc",
+		Src: &File{
+			MimeType:    docMimeType,
+			ExportLinks: map[string]string{"application/rtf": "https://example.com/rtf"},
+		},
+	})
+	if err == nil {
+		t.Fatal("download succeeded without a matching export link")
+	}
+	if !strings.Contains(err.Error(), docMimeType) || !strings.Contains(err.Error(), "text/plain") {
+		t.Errorf("error %q doesn't name the mime type and the formats tried", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) > 0 {
+		t.Errorf("download created %s", files[0].Name())
+	}
+}
@@ -15,10 +15,15 @@
 package drive
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"strings"
 	"time"
 
@@ -32,6 +37,12 @@ const (
 	GoogleOAuth2AuthURL  = "https://accounts.google.com/o/oauth2/auth"
 	GoogleOAuth2TokenURL = "https://accounts.google.com/o/oauth2/token"
 
+	// Google OAuth 2.0 token introspection URL.
+	GoogleOAuth2TokenInfoURL = "https://www.googleapis.com/oauth2/v1/tokeninfo"
+
+	// Google APIs root URL, used to check connectivity.
+	GoogleAPIsURL = "https://www.googleapis.com/"
+
 	// OAuth 2.0 OOB redirect URL for authorization.
 	RedirectURL = "urn:ietf:wg:oauth:2.0:oob"
 
@@ -40,21 +51,50 @@ const (
 
 	// OAuth 2.0 access type for offline/refresh access.
 	AccessType = "offline"
+
+	// Maximum number of concurrent transfers and traversal lookups,
+	// lowered temporarily if the remote throttles us.
+	maxNumOfConcTransfers  = maxNumOfConcPullTasks
+	maxNumOfConcTraversals = 10
+
+	// Deadline for each of the diagnostic requests made by doctor.
+	diagTimeout = 15 * time.Second
 )
 
 var (
 	ErrPathNotExists = errors.New("remote path doesn't exist")
+	ErrNoServerTime  = errors.New("remote responded without a valid Date header")
+)
+
+var (
+	// diagTransport and diagClient bound the diagnostic requests, so a
+	// stalled network is reported instead of hanging the doctor.
+	diagTransport = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		Dial:                  (&net.Dialer{Timeout: diagTimeout}).Dial,
+		TLSHandshakeTimeout:   diagTimeout,
+		ResponseHeaderTimeout: diagTimeout,
+	}
+	diagClient = &http.Client{Transport: diagTransport, Timeout: diagTimeout}
 )
 
 type Remote struct {
 	transport *oauth.Transport
 	service   *drive.Service
+
+	transfers  *limiter
+	traversals *limiter
 }
 
 func NewRemoteContext(context *config.Context) *Remote {
 	transport := newTransport(context)
 	service, _ := drive.New(transport.Client())
-	return &Remote{service: service, transport: transport}
+	return &Remote{
+		service:    service,
+		transport:  transport,
+		transfers:  newLimiter(maxNumOfConcTransfers),
+		traversals: newLimiter(maxNumOfConcTraversals),
+	}
 }
 
 func RetrieveRefreshToken(context *config.Context) (string, error) {
@@ -72,10 +112,70 @@ func RetrieveRefreshToken(context *config.Context) (string, error) {
 	return token.RefreshToken, nil
 }
 
+// TokenScopes refreshes the access token and returns the scopes it has
+// been granted.
+func (r *Remote) TokenScopes() (scopes []string, err error) {
+	t := *r.transport
+	t.Transport = diagTransport
+	if err = t.Refresh(); err != nil {
+		return
+	}
+	q := neturl.Values{"access_token": {t.Token.AccessToken}}
+	resp, err := diagClient.Get(GoogleOAuth2TokenInfoURL + "?" + q.Encode())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Scope string `json:"scope"`
+		Error string `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token is not valid: %s", info.Error)
+	}
+	return strings.Fields(info.Scope), nil
+}
+
+// ServerTime returns the time reported by the Google APIs front end.
+// It returns ErrNoServerTime if the front end is reachable but didn't
+// tell its time.
+func (r *Remote) ServerTime() (t time.Time, err error) {
+	resp, err := diagClient.Head(GoogleAPIsURL)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if t, err = http.ParseTime(resp.Header.Get("Date")); err != nil {
+		return t, ErrNoServerTime
+	}
+	return
+}
+
+// Quota returns the total and used storage in bytes, and how much of the
+// used storage is taken by trashed files.
+func (r *Remote) Quota() (total, used, trashed int64, err error) {
+	var about *drive.About
+	err = r.call(r.traversals, func() (err error) {
+		about, err = r.service.About.Get().Do()
+		return
+	})
+	if err != nil {
+		return
+	}
+	return about.QuotaBytesTotal, about.QuotaBytesUsed, about.QuotaBytesUsedInTrash, nil
+}
+
 func (r *Remote) FindById(id string) (file *File, err error) {
 	req := r.service.Files.Get(id)
 	var f *drive.File
-	if f, err = req.Do(); err != nil {
+	err = r.call(r.traversals, func() (err error) {
+		f, err = req.Do()
+		return
+	})
+	if err != nil {
 		return
 	}
 	return NewRemoteFile(f), nil
@@ -93,27 +193,40 @@ func (r *Remote) FindByParentId(parentId string) (files []*File, err error) {
 	req := r.service.Files.List()
 	// TODO: use field selectors
 	req.Q(fmt.Sprintf("'%s' in parents and trashed=false", parentId))
-	results, err := req.Do()
-	// TODO: handle paging
-	if err != nil {
-		return
-	}
-	for _, f := range results.Items {
-		if !strings.HasPrefix(f.Title, ".") { // ignore hidden files
-			files = append(files, NewRemoteFile(f))
+	for {
+		var results *drive.FileList
+		err = r.call(r.traversals, func() (err error) {
+			results, err = req.Do()
+			return
+		})
+		if err != nil {
+			return
 		}
+		for _, f := range results.Items {
+			if !strings.HasPrefix(f.Title, ".") { // ignore hidden files
+				files = append(files, NewRemoteFile(f))
+			}
+		}
+		if results.NextPageToken == "" {
+			return
+		}
+		req.PageToken(results.NextPageToken)
 	}
-	return
 }
 
 func (r *Remote) Trash(id string) error {
-	_, err := r.service.Files.Trash(id).Do()
-	return err
+	return r.call(r.transfers, func() error {
+		_, err := r.service.Files.Trash(id).Do()
+		return err
+	})
 }
 
 func (r *Remote) Publish(id string) (string, error) {
 	perm := &drive.Permission{Type: "anyone", Role: "reader"}
-	_, err := r.service.Permissions.Insert(id, perm).Do()
+	err := r.call(r.transfers, func() error {
+		_, err := r.service.Permissions.Insert(id, perm).Do()
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -127,11 +240,30 @@ func (r *Remote) Download(id string, exportUrl string) (io.ReadCloser, error) {
 	} else {
 		url = exportUrl
 	}
-	resp, err := r.transport.Client().Get(url)
-	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return resp.Body, err
-	}
-	return resp.Body, nil
+	var body io.ReadCloser
+	err := r.retry(maxNumOfThrottledRetries, func() error {
+		// the slot is held until the body is closed
+		r.transfers.acquire()
+		resp, err := r.transport.Client().Get(url)
+		if err != nil {
+			r.transfers.release()
+			return err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			r.transfers.release()
+			if err = throttledResponse(resp, string(b)); err != nil {
+				return err
+			}
+			body = ioutil.NopCloser(bytes.NewReader(b))
+			return nil
+		}
+		r.transfers.succeed()
+		body = &limitedReadCloser{rc: resp.Body, l: r.transfers}
+		return nil
+	})
+	return body, err
 }
 
 func (r *Remote) Upsert(parentId string, file *File, body io.Reader) (f *File, err error) {
@@ -143,25 +275,40 @@ func (r *Remote) Upsert(parentId string, file *File, body io.Reader) (f *File, e
 		uploaded.MimeType = "application/vnd.google-apps.folder"
 	}
 
-	if file.Id == "" {
-		req := r.service.Files.Insert(uploaded)
-		if !file.IsDir && body != nil {
-			req = req.Media(body)
+	// a body that can't be rewound can only be sent once
+	attempts := maxNumOfThrottledRetries
+	s, seekable := body.(io.Seeker)
+	if !file.IsDir && body != nil && !seekable {
+		attempts = 1
+	}
+	var updated *drive.File
+	err = r.callN(r.transfers, attempts, func() (err error) {
+		if !file.IsDir && seekable {
+			// rewind the body in case this is a retry
+			if _, err = s.Seek(0, 0); err != nil {
+				return
+			}
 		}
-		if uploaded, err = req.Do(); err != nil {
+		if file.Id == "" {
+			req := r.service.Files.Insert(uploaded)
+			if !file.IsDir && body != nil {
+				req = req.Media(body)
+			}
+			updated, err = req.Do()
 			return
 		}
-		return NewRemoteFile(uploaded), nil
-	}
-	// update the existing
-	req := r.service.Files.Update(file.Id, uploaded)
-	if !file.IsDir && body != nil {
-		req = req.Media(body)
-	}
-	if uploaded, err = req.Do(); err != nil {
+		// update the existing
+		req := r.service.Files.Update(file.Id, uploaded)
+		if !file.IsDir && body != nil {
+			req = req.Media(body)
+		}
+		updated, err = req.Do()
+		return
+	})
+	if err != nil {
 		return
 	}
-	return NewRemoteFile(uploaded), nil
+	return NewRemoteFile(updated), nil
 }
 
 func (r *Remote) findByPathRecv(parentId string, p []string) (file *File, err error) {
@@ -169,7 +316,11 @@ func (r *Remote) findByPathRecv(parentId string, p []string) (file *File, err er
 	req := r.service.Files.List()
 	// TODO: use field selectors
 	req.Q(fmt.Sprintf("'%s' in parents and title = '%s' and trashed=false", parentId, p[0]))
-	files, err := req.Do()
+	var files *drive.FileList
+	err = r.call(r.traversals, func() (err error) {
+		files, err = req.Do()
+		return
+	})
 	if err != nil || len(files.Items) < 1 {
 		// TODO: make sure only 404s are handled here
 		return nil, ErrPathNotExists
@@ -181,6 +332,50 @@ func (r *Remote) findByPathRecv(parentId string, p []string) (file *File, err er
 	return r.findByPathRecv(file.Id, p[1:])
 }
 
+// call runs fn in a slot of l, retrying if the remote throttles us.
+func (r *Remote) call(l *limiter, fn func() error) error {
+	return r.callN(l, maxNumOfThrottledRetries, fn)
+}
+
+// callN is like call, but makes at most attempts attempts.
+func (r *Remote) callN(l *limiter, attempts int, fn func() error) error {
+	return r.retry(attempts, func() error {
+		l.acquire()
+		defer l.release()
+		err := fn()
+		if err == nil {
+			l.succeed()
+		}
+		return err
+	})
+}
+
+// retry runs fn until it succeeds, fails with a non-throttling error or
+// runs out of attempts. Each throttled attempt shrinks both the transfer
+// and traversal limits and waits for Retry-After or an exponential delay.
+func (r *Remote) retry(attempts int, fn func() error) (err error) {
+	delay := throttleBaseDelay
+	for i := 0; i < attempts; i++ {
+		err = fn()
+		te, ok := asThrottled(err)
+		if !ok {
+			return
+		}
+		r.transfers.backoff()
+		r.traversals.backoff()
+		if i == attempts-1 {
+			break
+		}
+		wait := delay
+		if te.retryAfter > 0 {
+			wait = te.retryAfter
+		}
+		sleep(wait)
+		delay *= 2
+	}
+	return
+}
+
 func newAuthConfig(context *config.Context) *oauth.Config {
 	return &oauth.Config{
 		ClientId:     context.ClientId,
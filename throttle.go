@@ -0,0 +1,192 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/google-api-go-client/googleapi"
+)
+
+const (
+	// Maximum number of attempts for a throttled request.
+	maxNumOfThrottledRetries = 6
+
+	// Initial wait before retrying a throttled request if the
+	// server didn't suggest one with Retry-After.
+	throttleBaseDelay = time.Second
+
+	// Longest wait before retrying a throttled request, whatever the
+	// server suggested with Retry-After.
+	maxThrottleDelay = 2 * (throttleBaseDelay << maxNumOfThrottledRetries)
+)
+
+// sleep waits before retrying a throttled request; replaced in tests.
+var sleep = time.Sleep
+
+// throttledError is returned for responses that tell the client to
+// slow down, e.g. rateLimitExceeded or a 429 with Retry-After.
+type throttledError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("throttled by the remote: %v", e.err)
+}
+
+// limiter is a counting semaphore whose size adapts to the remote.
+// The size is halved each time the remote throttles us and grows back
+// by one after size consecutive successful requests, never exceeding max.
+// Backoffs within throttleBaseDelay of the previous one are ignored, so a
+// burst of throttled requests only halves the size once.
+type limiter struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	max         int
+	size        int
+	active      int
+	streak      int
+	lastBackoff time.Time
+}
+
+func newLimiter(max int) *limiter {
+	l := &limiter{max: max, size: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	for l.active >= l.size {
+		l.cond.Wait()
+	}
+	l.active++
+	l.mu.Unlock()
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Signal()
+}
+
+func (l *limiter) backoff() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.streak = 0
+	now := time.Now()
+	if now.Sub(l.lastBackoff) < throttleBaseDelay {
+		return
+	}
+	l.lastBackoff = now
+	if l.size > 1 {
+		l.size /= 2
+	}
+}
+
+func (l *limiter) succeed() {
+	l.mu.Lock()
+	l.streak++
+	grown := false
+	if l.streak >= l.size && l.size < l.max {
+		l.size++
+		l.streak = 0
+		grown = true
+	}
+	l.mu.Unlock()
+	if grown {
+		l.cond.Signal()
+	}
+}
+
+// limitedReadCloser releases its limiter slot once the body is closed,
+// so a download holds its slot for the whole transfer.
+type limitedReadCloser struct {
+	rc   io.ReadCloser
+	l    *limiter
+	once sync.Once
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	return r.rc.Read(p)
+}
+
+func (r *limitedReadCloser) Close() error {
+	r.once.Do(r.l.release)
+	return r.rc.Close()
+}
+
+// asThrottled converts err into a *throttledError if it reports that
+// the user or project rate limit has been exceeded.
+func asThrottled(err error) (*throttledError, bool) {
+	switch e := err.(type) {
+	case *throttledError:
+		return e, true
+	case *googleapi.Error:
+		if e.Code == 429 || (e.Code == http.StatusForbidden && isRateLimitReason(e.Body)) {
+			return &throttledError{err: e}, true
+		}
+	}
+	return nil, false
+}
+
+// throttledResponse returns a *throttledError if resp tells the client
+// to slow down, honoring its Retry-After header if any.
+func throttledResponse(resp *http.Response, body string) error {
+	switch {
+	case resp.StatusCode == 429:
+	case resp.StatusCode == http.StatusForbidden && isRateLimitReason(body):
+	case resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "":
+	default:
+		return nil
+	}
+	return &throttledError{
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		err:        fmt.Errorf("%s", resp.Status),
+	}
+}
+
+func isRateLimitReason(body string) bool {
+	return strings.Contains(body, "rateLimitExceeded") ||
+		strings.Contains(body, "userRateLimitExceeded")
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date, capping the result at maxThrottleDelay.
+func parseRetryAfter(v string) (d time.Duration) {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(time.Now())
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxThrottleDelay {
+		return maxThrottleDelay
+	}
+	return
+}
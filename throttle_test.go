@@ -0,0 +1,315 @@
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.google.com/p/google-api-go-client/googleapi"
+)
+
+func TestLimiterAcquireBlocksAtSize(t *testing.T) {
+	l := newLimiter(2)
+	l.acquire()
+	l.acquire()
+	acquired := make(chan bool)
+	go func() {
+		l.acquire()
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot beyond the limiter's size")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter is not woken up on release")
+	}
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	l := newLimiter(10)
+	for _, want := range []int{5, 2, 1, 1} {
+		l.lastBackoff = time.Time{}
+		l.backoff()
+		if l.size != want {
+			t.Errorf("size after backoff = %d, want %d", l.size, want)
+		}
+	}
+}
+
+func TestLimiterBackoffOncePerWindow(t *testing.T) {
+	l := newLimiter(10)
+	for i := 0; i < 5; i++ {
+		l.backoff()
+	}
+	if l.size != 5 {
+		t.Errorf("size after a burst of backoffs = %d, want 5", l.size)
+	}
+}
+
+func TestLimiterSucceedGrowsToMax(t *testing.T) {
+	l := newLimiter(4)
+	l.backoff()
+	if l.size != 2 {
+		t.Fatalf("size after backoff = %d, want 2", l.size)
+	}
+	for i := 0; i < 100; i++ {
+		l.succeed()
+	}
+	if l.size != 4 {
+		t.Errorf("size after successes = %d, want 4", l.size)
+	}
+}
+
+func TestLimiterGrowthWakesWaiter(t *testing.T) {
+	l := newLimiter(2)
+	l.backoff()
+	l.acquire()
+	acquired := make(chan bool)
+	go func() {
+		l.acquire()
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot beyond the limiter's size")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.succeed()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter is not woken up on growth")
+	}
+}
+
+func TestAsThrottled(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{&throttledError{}, true},
+		{&googleapi.Error{Code: 429}, true},
+		{&googleapi.Error{Code: 403, Body: `{"error":{"errors":[{"reason":"userRateLimitExceeded"}]}}`}, true},
+		{&googleapi.Error{Code: 403, Body: `{"error":{"errors":[{"reason":"insufficientPermissions"}]}}`}, false},
+		{&googleapi.Error{Code: 500}, false},
+	}
+	for _, tt := range tests {
+		if _, got := asThrottled(tt.err); got != tt.want {
+			t.Errorf("asThrottled(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestThrottledResponse(t *testing.T) {
+	tests := []struct {
+		code       int
+		retryAfter string
+		body       string
+		want       bool
+		wantAfter  time.Duration
+	}{
+		{200, "", "", false, 0},
+		{404, "", "", false, 0},
+		{429, "", "", true, 0},
+		{429, "3", "", true, 3 * time.Second},
+		{403, "", `{"reason":"rateLimitExceeded"}`, true, 0},
+		{403, "", `{"reason":"forbidden"}`, false, 0},
+		{503, "", "", false, 0},
+		{503, "7", "", true, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.retryAfter != "" {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(tt.code)
+			w.Write([]byte(tt.body))
+		}))
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		ts.Close()
+
+		err = throttledResponse(resp, string(body))
+		te, got := asThrottled(err)
+		if got != tt.want {
+			t.Errorf("%d %q: throttled = %v, want %v", tt.code, tt.body, got, tt.want)
+			continue
+		}
+		if got && te.retryAfter != tt.wantAfter {
+			t.Errorf("%d %q: retryAfter = %v, want %v", tt.code, tt.body, te.retryAfter, tt.wantAfter)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"junk", 0},
+		{"-5", 0},
+		{"12", 12 * time.Second},
+		{"100000", maxThrottleDelay},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+		{time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), maxThrottleDelay},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.v); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+	// an HTTP date within the cap is honored, give or take a second
+	d := parseRetryAfter(time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat))
+	if d < 28*time.Second || d > 30*time.Second {
+		t.Errorf("parseRetryAfter(now+30s) = %v, want about 30s", d)
+	}
+}
+
+// stubSleep records the waits of throttled retries instead of sleeping,
+// and checks that no slot of the remote's limiters is held meanwhile.
+func stubSleep(t *testing.T, r *Remote) (waits *[]time.Duration, restore func()) {
+	waits = &[]time.Duration{}
+	sleep = func(d time.Duration) {
+		if r.transfers.active != 0 || r.traversals.active != 0 {
+			t.Errorf("sleeping with %d transfer and %d traversal slots held",
+				r.transfers.active, r.traversals.active)
+		}
+		*waits = append(*waits, d)
+	}
+	return waits, func() { sleep = time.Sleep }
+}
+
+func newTestRemote() *Remote {
+	return &Remote{transfers: newLimiter(4), traversals: newLimiter(10)}
+}
+
+func TestCallStopsOnNonThrottlingError(t *testing.T) {
+	r := newTestRemote()
+	waits, restore := stubSleep(t, r)
+	defer restore()
+
+	boom := errors.New("boom")
+	calls := 0
+	err := r.call(r.transfers, func() error {
+		calls++
+		return boom
+	})
+	if err != boom {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+	if calls != 1 || len(*waits) != 0 {
+		t.Errorf("calls = %d, waits = %v; want 1 call and no wait", calls, *waits)
+	}
+	if r.transfers.size != 4 || r.traversals.size != 10 {
+		t.Errorf("sizes = %d, %d; want 4, 10", r.transfers.size, r.traversals.size)
+	}
+}
+
+func TestCallAttemptCap(t *testing.T) {
+	r := newTestRemote()
+	waits, restore := stubSleep(t, r)
+	defer restore()
+
+	calls := 0
+	err := r.call(r.traversals, func() error {
+		calls++
+		return &googleapi.Error{Code: 429}
+	})
+	if _, ok := asThrottled(err); !ok {
+		t.Errorf("err = %v, want a throttled error", err)
+	}
+	if calls != maxNumOfThrottledRetries {
+		t.Errorf("calls = %d, want %d", calls, maxNumOfThrottledRetries)
+	}
+	want := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second}
+	if !reflect.DeepEqual(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+}
+
+func TestCallNSingleAttempt(t *testing.T) {
+	r := newTestRemote()
+	waits, restore := stubSleep(t, r)
+	defer restore()
+
+	calls := 0
+	r.callN(r.transfers, 1, func() error {
+		calls++
+		return &googleapi.Error{Code: 429}
+	})
+	if calls != 1 || len(*waits) != 0 {
+		t.Errorf("calls = %d, waits = %v; want 1 call and no wait", calls, *waits)
+	}
+}
+
+func TestCallBacksOffBothLimiters(t *testing.T) {
+	r := newTestRemote()
+	_, restore := stubSleep(t, r)
+	defer restore()
+
+	throttled := true
+	err := r.call(r.transfers, func() error {
+		if throttled {
+			throttled = false
+			return &googleapi.Error{Code: 429}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.transfers.size != 2 || r.traversals.size != 5 {
+		t.Errorf("sizes = %d, %d; want 2, 5", r.transfers.size, r.traversals.size)
+	}
+	if r.transfers.active != 0 {
+		t.Errorf("%d transfer slots still held", r.transfers.active)
+	}
+}
+
+func TestCallPrefersRetryAfter(t *testing.T) {
+	r := newTestRemote()
+	waits, restore := stubSleep(t, r)
+	defer restore()
+
+	calls := 0
+	r.call(r.transfers, func() error {
+		if calls++; calls < 3 {
+			return &throttledError{retryAfter: 5 * time.Second, err: errors.New("429")}
+		}
+		return nil
+	})
+	want := []time.Duration{5 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+}